@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	log "github.com/sirupsen/logrus"
+)
+
+// awsImportPollInterval is how often Upload polls ImportSnapshot's status;
+// the import itself typically takes minutes, so there's no need to poll
+// tighter than this.
+const awsImportPollInterval = 15 * time.Second
+
+// waitForImportSnapshot polls DescribeImportSnapshotTasks until importTaskID
+// finishes, returning the resulting snapshot id. ImportSnapshotWithContext
+// only starts an async task - its response carries no usable SnapshotId yet.
+func waitForImportSnapshot(ctx context.Context, svc *ec2.EC2, importTaskID string) (string, error) {
+	for {
+		out, err := svc.DescribeImportSnapshotTasksWithContext(ctx, &ec2.DescribeImportSnapshotTasksInput{
+			ImportTaskIds: []*string{aws.String(importTaskID)},
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(out.ImportSnapshotTasks) == 0 {
+			return "", fmt.Errorf("import snapshot task %s not found", importTaskID)
+		}
+
+		detail := out.ImportSnapshotTasks[0].SnapshotTaskDetail
+		switch aws.StringValue(detail.Status) {
+		case "completed":
+			return aws.StringValue(detail.SnapshotId), nil
+		case "deleted", "deleted (cancelled)", "cancelled":
+			return "", fmt.Errorf("import snapshot task %s: %s: %s", importTaskID, aws.StringValue(detail.Status), aws.StringValue(detail.StatusMessage))
+		}
+
+		log.Debugf("import snapshot task %s: %s (%s%%)", importTaskID, aws.StringValue(detail.Status), aws.StringValue(detail.Progress))
+
+		select {
+		case <-time.After(awsImportPollInterval):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// AWSUploadOptions is the per-release "aws" upload target config, set via
+// the `upload_targets` list in the YAML config.
+type AWSUploadOptions struct {
+	Bucket  string   `yaml:"bucket"`
+	Regions []string `yaml:"regions"`
+}
+
+// AWSImageUploader converts a fetched qcow2 Image to a raw disk, imports it
+// into EC2 as a snapshot via S3, registers an AMI from that snapshot in the
+// home region and then copies the AMI into every other configured region.
+type AWSImageUploader struct {
+	Options AWSUploadOptions
+	Session *session.Session
+}
+
+func NewAWSImageUploader(options AWSUploadOptions) (*AWSImageUploader, error) {
+	if len(options.Regions) == 0 {
+		return nil, fmt.Errorf("aws upload target: regions must list at least one region (the first is used as the home region)")
+	}
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(options.Regions[0])})
+	if err != nil {
+		return nil, err
+	}
+	return &AWSImageUploader{Options: options, Session: sess}, nil
+}
+
+// imageName folds the upstream serial into the uploaded AMI's name, the same
+// way GlanceImageUploader.imageName does, so a rebuild of the same
+// release/arch gets a distinct name instead of colliding with the previous
+// RegisterImage call.
+func (uploader *AWSImageUploader) imageName(image *Image) string {
+	base := fmt.Sprintf("%s-%s-%s", image.Distro, image.Release, image.Architecture)
+	if image.Serial == "" {
+		return base
+	}
+	return fmt.Sprintf("%s-%s", base, image.Serial)
+}
+
+func (uploader *AWSImageUploader) Name() string { return "aws" }
+
+// Upload converts the image to raw format, stages it in S3 and imports it
+// as a snapshot, registers an AMI in the home region and copies it to every
+// other region in Options.Regions.
+func (uploader *AWSImageUploader) Upload(ctx context.Context, image *Image) (*UploadResult, error) {
+	imageName := uploader.imageName(image)
+	log.Infof("Uploading image:%s to AWS (bucket %s)", imageName, uploader.Options.Bucket)
+
+	rawFile, err := convertImageFormat(ctx, image.File.Name(), "raw")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(rawFile)
+
+	f, err := os.Open(rawFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	key := fmt.Sprintf("%s.raw", imageName)
+	uploaderClient := s3manager.NewUploader(uploader.Session)
+	if _, err := uploaderClient.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(uploader.Options.Bucket),
+		Key:    aws.String(key),
+		Body:   f,
+	}); err != nil {
+		return nil, err
+	}
+
+	svc := ec2.New(uploader.Session)
+	importOut, err := svc.ImportSnapshotWithContext(ctx, &ec2.ImportSnapshotInput{
+		Description: aws.String(imageName),
+		DiskContainer: &ec2.SnapshotDiskContainer{
+			Format: aws.String("raw"),
+			UserBucket: &ec2.UserBucket{
+				S3Bucket: aws.String(uploader.Options.Bucket),
+				S3Key:    aws.String(key),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotID, err := waitForImportSnapshot(ctx, svc, aws.StringValue(importOut.ImportTaskId))
+	if err != nil {
+		return nil, fmt.Errorf("waiting for snapshot import of %s: %s", imageName, err)
+	}
+
+	registerOut, err := svc.RegisterImageWithContext(ctx, &ec2.RegisterImageInput{
+		Name:         aws.String(imageName),
+		Architecture: aws.String(image.Architecture),
+		RootDeviceName: aws.String("/dev/sda1"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{{
+			DeviceName: aws.String("/dev/sda1"),
+			Ebs:        &ec2.EbsBlockDevice{SnapshotId: aws.String(snapshotID)},
+		}},
+		VirtualizationType: aws.String("hvm"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	homeRegion := uploader.Options.Regions[0]
+	for _, region := range uploader.Options.Regions[1:] {
+		regionSvc := ec2.New(uploader.Session, aws.NewConfig().WithRegion(region))
+		if _, err := regionSvc.CopyImageWithContext(ctx, &ec2.CopyImageInput{
+			Name:          aws.String(imageName),
+			SourceImageId: registerOut.ImageId,
+			SourceRegion:  aws.String(homeRegion),
+		}); err != nil {
+			return nil, fmt.Errorf("copying %s to region %s: %s", imageName, region, err)
+		}
+	}
+
+	return &UploadResult{Backend: "aws", ID: aws.StringValue(registerOut.ImageId), Location: homeRegion}, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2021-07-01/compute"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	log "github.com/sirupsen/logrus"
+)
+
+// azurePageBlobChunkSize is the size of each UploadPages call. It must stay
+// a multiple of 512 (the page blob alignment requirement); fixed VHDs are
+// already padded to 512 bytes by their footer, so chunking on this boundary
+// never splits a page.
+const azurePageBlobChunkSize = 4 * 1024 * 1024
+
+// uploadVHDPages reads file in azurePageBlobChunkSize pieces and writes each
+// as a page range to blobURL. blobURL.Create only allocates an empty page
+// blob of the right size; without this, the Shared Image Gallery version
+// created afterwards would point at all-zero content.
+func uploadVHDPages(ctx context.Context, blobURL azblob.PageBlobURL, file *os.File, size int64) error {
+	buf := make([]byte, azurePageBlobChunkSize)
+
+	for offset := int64(0); offset < size; offset += azurePageBlobChunkSize {
+		n, err := io.ReadFull(file, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+
+		if _, err := blobURL.UploadPages(ctx, offset, bytes.NewReader(buf[:n]), azblob.PageBlobAccessConditions{}, nil, azblob.ClientProvidedKeyOptions{}); err != nil {
+			return fmt.Errorf("uploading page range at offset %d: %s", offset, err)
+		}
+	}
+
+	return nil
+}
+
+func mustParseURL(rawurl string) *url.URL {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// AzureUploadOptions is the per-release "azure" upload target config.
+type AzureUploadOptions struct {
+	StorageAccount string   `yaml:"storage_account"`
+	Container      string   `yaml:"container"`
+	ResourceGroup  string   `yaml:"resource_group"`
+	Gallery        string   `yaml:"gallery"`
+	ImageDefinition string  `yaml:"image_definition"`
+	TargetRegions  []string `yaml:"target_regions"`
+	ReplicaCount   int32    `yaml:"replica_count"`
+}
+
+// AzureImageUploader uploads the VHD-converted disk to a storage account
+// container and publishes it as a new Shared Image Gallery image version.
+type AzureImageUploader struct {
+	Options        AzureUploadOptions
+	Authorizer     auth.EnvironmentSettings
+	SubscriptionID string
+}
+
+func NewAzureImageUploader(options AzureUploadOptions, subscriptionID string) (*AzureImageUploader, error) {
+	settings, err := auth.GetSettingsFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	return &AzureImageUploader{Options: options, Authorizer: settings, SubscriptionID: subscriptionID}, nil
+}
+
+// imageName folds the upstream serial into the blob object name, the same way GlanceImageUploader.imageName does, so a rebuild of
+// the same release/arch doesn't overwrite the previous blob before the new
+// Gallery Image Version referencing it exists.
+func (uploader *AzureImageUploader) imageName(image *Image) string {
+	base := fmt.Sprintf("%s-%s-%s", image.Distro, image.Release, image.Architecture)
+	if image.Serial == "" {
+		return base
+	}
+	return fmt.Sprintf("%s-%s", base, image.Serial)
+}
+
+// galleryVersionName turns image.Serial into the major.minor.patch form the
+// Shared Image Gallery API requires for a Gallery Image Version name - unlike
+// imageName, this can't be the descriptive "<distro>-<release>-<arch>"
+// string, which CreateOrUpdate rejects outright. Serials come as either an
+// 8-digit simplestreams date (Ubuntu, e.g. "20240115") or our own
+// httpLastModifiedSerial date (Debian, Ubuntu Core) - both parse as
+// YYYYMMDD, so they split cleanly into (year, month, day). A missing or
+// unparseable serial falls back to 0.0.0.
+func galleryVersionName(serial string) string {
+	if len(serial) != 8 {
+		return "0.0.0"
+	}
+
+	year, err1 := strconv.Atoi(serial[0:4])
+	month, err2 := strconv.Atoi(serial[4:6])
+	day, err3 := strconv.Atoi(serial[6:8])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return "0.0.0"
+	}
+
+	return fmt.Sprintf("%d.%d.%d", year, month, day)
+}
+
+func (uploader *AzureImageUploader) galleryImagesClient() (compute.GalleryImageVersionsClient, error) {
+	client := compute.NewGalleryImageVersionsClient(uploader.SubscriptionID)
+	authorizer, err := uploader.Authorizer.GetAuthorizer()
+	if err != nil {
+		return client, err
+	}
+	client.Authorizer = authorizer
+	return client, nil
+}
+
+func (uploader *AzureImageUploader) Name() string { return "azure" }
+
+// Upload converts the image to a fixed VHD, uploads it as a page blob to
+// the configured storage account container and creates a new Shared Image
+// Gallery image version replicated to Options.TargetRegions.
+func (uploader *AzureImageUploader) Upload(ctx context.Context, image *Image) (*UploadResult, error) {
+	imageName := uploader.imageName(image)
+	log.Infof("Uploading image:%s to Azure Shared Image Gallery %s", imageName, uploader.Options.Gallery)
+
+	vhdFile, err := convertImageFormat(ctx, image.File.Name(), "vhd")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(vhdFile)
+
+	f, err := os.Open(vhdFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	credential, err := azblob.NewSharedKeyCredential(uploader.Options.StorageAccount, os.Getenv("AZURE_STORAGE_KEY"))
+	if err != nil {
+		return nil, err
+	}
+
+	blobURL := azblob.NewContainerURL(
+		mustParseURL(fmt.Sprintf("https://%s.blob.core.windows.net/%s", uploader.Options.StorageAccount, uploader.Options.Container)),
+		azblob.NewPipeline(credential, azblob.PipelineOptions{}),
+	).NewPageBlobURL(fmt.Sprintf("%s.vhd", imageName))
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := blobURL.Create(ctx, stat.Size(), 0, azblob.BlobHTTPHeaders{}, azblob.Metadata{}, azblob.BlobAccessConditions{}, azblob.DefaultPremiumBlobAccessTier, nil, azblob.ClientProvidedKeyOptions{}, azblob.ImmutabilityPolicyOptions{}); err != nil {
+		return nil, err
+	}
+
+	if err := uploadVHDPages(ctx, blobURL, f, stat.Size()); err != nil {
+		return nil, err
+	}
+
+	client, err := uploader.galleryImagesClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var targetRegions []compute.TargetRegion
+	for _, region := range uploader.Options.TargetRegions {
+		targetRegions = append(targetRegions, compute.TargetRegion{
+			Name:                 &region,
+			RegionalReplicaCount: &uploader.Options.ReplicaCount,
+		})
+	}
+
+	versionName := galleryVersionName(image.Serial)
+	blobURI := blobURL.URL().String()
+	future, err := client.CreateOrUpdate(ctx, uploader.Options.ResourceGroup, uploader.Options.Gallery, uploader.Options.ImageDefinition, versionName, compute.GalleryImageVersion{
+		GalleryImageVersionProperties: &compute.GalleryImageVersionProperties{
+			PublishingProfile: &compute.GalleryImageVersionPublishingProfile{TargetRegions: &targetRegions},
+			StorageProfile: &compute.GalleryImageVersionStorageProfile{
+				OsDiskImage: &compute.GalleryOSDiskImage{
+					Source: &compute.GalleryArtifactVersionSource{ID: &blobURI},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := future.WaitForCompletionRef(ctx, client.Client); err != nil {
+		return nil, err
+	}
+
+	return &UploadResult{Backend: "azure", ID: versionName, Location: uploader.Options.Gallery}, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// newHasher returns the hash.Hash for one of the digest algorithms upstream
+// metadata publishes (simplestreams uses sha256, Debian's SHA512SUMS uses
+// sha512).
+func newHasher(algo string) (hash.Hash, error) {
+	switch algo {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm: %s", algo)
+}
+
+// fileSHA256 hashes the file at path without holding it open, so it can be
+// called against a file another part of the pipeline still has open for
+// writing/reading elsewhere.
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -6,7 +6,40 @@ import (
 )
 
 type Release struct {
-	Architectures []string `yaml:"archs"`
+	Architectures  []string              `yaml:"archs"`
+	UploadTargets  []UploadTarget        `yaml:"upload_targets,omitempty"`
+	PostProcessors []PostProcessorConfig `yaml:"post_process,omitempty"`
+
+	// Channel, Track and Model are only read by the ubuntu-core fetcher:
+	// Track/Channel make up the snap channel ("<track>/<channel>", e.g.
+	// "20/stable") passed to `snap prepare-image`, and Model points at the
+	// model assertion used to build a seed when upstream hasn't published a
+	// preinstalled image for the requested architecture.
+	Channel string `yaml:"channel,omitempty"`
+	Track   string `yaml:"track,omitempty"`
+	Model   string `yaml:"model,omitempty"`
+}
+
+// UploadTarget declares one backend a release's fetched images should be
+// published to. Backend selects which of the nested option structs is
+// read; the others are left zero-valued.
+type UploadTarget struct {
+	Backend string             `yaml:"backend"`
+	AWS     AWSUploadOptions   `yaml:"aws,omitempty"`
+	Azure   AzureUploadOptions `yaml:"azure,omitempty"`
+	GCP     GCPUploadOptions   `yaml:"gcp,omitempty"`
+}
+
+// PostProcessorConfig declares one step of a release's post_process
+// pipeline, run in list order between fetching and uploading. Only the
+// fields relevant to Type are read.
+type PostProcessorConfig struct {
+	Type     string `yaml:"type"` // convert, sparsify, resize, cloud-init
+	Format   string `yaml:"format,omitempty"`    // convert: qemu-img target format (raw, vhd, vmdk, qcow2)
+	Size     string `yaml:"size,omitempty"`      // resize: qemu-img resize argument, e.g. "+2G"
+	CloudCfg string `yaml:"cloud_cfg,omitempty"` // cloud-init: local path to a cloud.cfg.d/*.cfg snippet
+	MetaData string `yaml:"meta_data,omitempty"` // cloud-init: local path to a NoCloud meta-data file
+	UserData string `yaml:"user_data,omitempty"` // cloud-init: local path to a NoCloud user-data file
 }
 
 type DistroSource struct {
@@ -17,6 +50,11 @@ type DistroSource struct {
 type ImageSource struct {
 	URLS          []string                `yaml:"urls,omitempty"`
 	DistroSources map[string]DistroSource `yaml:"distros,omitempty"`
+
+	// StateFile points at the JSON file tracking what's already been
+	// uploaded (upstream URL/checksum/serial, Glance image ID) so restarts
+	// don't re-upload an unchanged image. Defaults to DefaultStateFile.
+	StateFile string `yaml:"state_file,omitempty"`
 }
 
 type Config struct {
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"crypto/sha256"
+	"context"
+	"encoding/hex"
 	"fmt"
 	"github.com/juju/errors"
 	"github.com/juju/juju/environs/imagedownloads"
@@ -10,9 +11,9 @@ import (
 	log "github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -21,39 +22,192 @@ import (
 type Image struct {
 	Distro, Architecture, Release string
 	File                          *os.File
+	// UpstreamURL and UpstreamChecksumAlgo/UpstreamChecksum are the values
+	// the fetcher verified File against, carried forward so the upload
+	// stage can record them in the state store without re-deriving them.
+	UpstreamURL          string
+	UpstreamChecksumAlgo string
+	UpstreamChecksum     string
+	// Serial is the upstream version/build identifier (a simplestreams
+	// serial, a Last-Modified date, ...) reported by the fetcher, folded
+	// into the uploaded image's name so upstream rebuilds don't collide.
+	Serial string
+	// Steps records the sha256 of File after each PostProcessor the image
+	// passed through, in order, so a later stage (or the state store) can
+	// tell what shape the artifact is in without recomputing it.
+	Steps []ProcessingStep
 }
 
+type ProcessingStep struct {
+	Processor string
+	SHA256    string
+}
+
+// ImageUploadHandler fans every fetched Image out to the Uploader backends
+// configured for its distro/release (falling back to Glance alone when a
+// release declares no upload_targets), each running concurrently.
 type ImageUploadHandler struct {
 	ErrorChannel *chan error
-	Uploader     *GlanceImageUploader
+	Glance       *GlanceImageUploader
+	Targets      map[string][]Uploader
+	State        *StateStore
 }
 
-func NewImageUploadHandler(cloudname string, configPath string, errChannel *chan error) (*ImageUploadHandler, error) {
-	glanceImageUploader, err := NewGlanceImageUploader(cloudname, configPath)
+func targetsKey(distro, release string) string {
+	return fmt.Sprintf("%s/%s", distro, release)
+}
+
+func newUploaderForTarget(target UploadTarget, glanceUploader *GlanceImageUploader) (Uploader, error) {
+	switch target.Backend {
+		case "", "glance": {
+			return glanceUploader, nil
+		}
+		case "aws": {
+			return NewAWSImageUploader(target.AWS)
+		}
+		case "azure": {
+			return NewAzureImageUploader(target.Azure, os.Getenv("AZURE_SUBSCRIPTION_ID"))
+		}
+		case "gcp": {
+			return NewGCPImageUploader(context.Background(), target.GCP)
+		}
+	}
+	return nil, fmt.Errorf("unknown upload backend: %s", target.Backend)
+}
+
+func NewImageUploadHandler(cloudname string, configPath string, config ImageSource, errChannel *chan error) (*ImageUploadHandler, error) {
+	stateFilePath := config.StateFile
+	if stateFilePath == "" {
+		stateFilePath = DefaultStateFile
+	}
+
+	state, err := NewStateStore(stateFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	glanceImageUploader, err := NewGlanceImageUploader(cloudname, configPath, state)
 	if err != nil {
 		return nil, err
 	}
-	return &ImageUploadHandler{ErrorChannel: errChannel, Uploader: glanceImageUploader}, nil
+
+	targets := make(map[string][]Uploader)
+	for distro, distroConfig := range config.DistroSources {
+		for release, releaseConfig := range distroConfig.Releases {
+			var uploaders []Uploader
+			if len(releaseConfig.UploadTargets) == 0 {
+				uploaders = append(uploaders, glanceImageUploader)
+			} else {
+				for _, target := range releaseConfig.UploadTargets {
+					targetUploader, err := newUploaderForTarget(target, glanceImageUploader)
+					if err != nil {
+						return nil, err
+					}
+					uploaders = append(uploaders, targetUploader)
+				}
+			}
+			targets[targetsKey(distro, release)] = uploaders
+		}
+	}
+
+	return &ImageUploadHandler{ErrorChannel: errChannel, Glance: glanceImageUploader, Targets: targets, State: state}, nil
+}
+
+// uploadersFor returns the Uploaders configured for a distro/release,
+// falling back to Glance alone when none are configured - the same
+// fallback Handle applies when it actually fans an Image out.
+func (handler *ImageUploadHandler) uploadersFor(distro, release string) []Uploader {
+	uploaders, ok := handler.Targets[targetsKey(distro, release)]
+	if !ok || len(uploaders) == 0 {
+		uploaders = []Uploader{handler.Glance}
+	}
+	return uploaders
+}
+
+// FilterFetchers skips a fetcher only when every backend configured for its
+// distro/release already has a state record matching a fresh upstream
+// checksum probe - so a release uploaded only to aws/azure/gcp is governed
+// by its own backend's state instead of always falling through to whatever
+// Glance happens to have (or not have).
+func (handler *ImageUploadHandler) FilterFetchers(fetchers []ImageFetcher) []ImageFetcher {
+	var filtered []ImageFetcher
+	for _, fetcher := range fetchers {
+		uploaders := handler.uploadersFor(fetcher.GetDistro(), fetcher.GetRelease())
+		if handler.needsFetch(fetcher, uploaders) {
+			filtered = append(filtered, fetcher)
+		}
+	}
+	log.Infof("Found %d new images to fetch", len(filtered))
+	return filtered
+}
+
+func (handler *ImageUploadHandler) needsFetch(fetcher ImageFetcher, uploaders []Uploader) bool {
+	key := fmt.Sprintf("%s-%s-%s", fetcher.GetDistro(), fetcher.GetRelease(), fetcher.GetArchitecture())
+
+	_, digest, err := fetcher.ExpectedChecksum()
+	if err != nil {
+		log.Warnf("%s: could not probe upstream checksum (%s), fetching anyway", fetcher.GetName(), err)
+		return true
+	}
+
+	for _, uploader := range uploaders {
+		state, ok := handler.State.Get(stateKey(uploader.Name(), key))
+		if !ok {
+			log.Infof("%s: adding to the list of images to fetch (no prior %s state)", fetcher.GetName(), uploader.Name())
+			return true
+		}
+		if digest == "" || !strings.EqualFold(digest, state.Digest) {
+			log.Infof("%s: upstream checksum changed for %s (%s -> %s), re-fetching", fetcher.GetName(), uploader.Name(), state.Digest, digest)
+			return true
+		}
+	}
+
+	return false
 }
 
-func (uploader *ImageUploadHandler) Handle(images *chan Image) {
+func (handler *ImageUploadHandler) Handle(images *chan Image) {
 	for {
 		select {
 			case image := <-*images: {
-				go uploader.Uploader.Upload(&image, uploader.ErrorChannel)
+				uploaders := handler.uploadersFor(image.Distro, image.Release)
+
+				for _, uploader := range uploaders {
+					go func(uploader Uploader, image Image) {
+						result, err := uploader.Upload(context.Background(), &image)
+						if err != nil {
+							*handler.ErrorChannel <- err
+							return
+						}
+
+						key := fmt.Sprintf("%s-%s-%s", image.Distro, image.Release, image.Architecture)
+						if err := handler.State.Put(stateKey(uploader.Name(), key), ImageState{
+							UpstreamURL:    image.UpstreamURL,
+							DigestAlgo:     image.UpstreamChecksumAlgo,
+							Digest:         image.UpstreamChecksum,
+							UpstreamSerial: image.Serial,
+							UploadedID:     result.ID,
+							UpdatedAt:      time.Now(),
+						}); err != nil {
+							log.Warnf("recording state for %s/%s: %s", uploader.Name(), key, err)
+						}
+					}(uploader, image)
+				}
 			}
 		}
 	}
 }
 
 type ImageFetchHandler struct {
-	ImagesChannel *chan Image
-	ErrorChannel  *chan error
-	Fetchers      []ImageFetcher
-	WaitGroup     *sync.WaitGroup
-	Config        ImageSource
-	Name          string
-	ImageBasePath string
+	ImagesChannel   *chan Image
+	ErrorChannel    *chan error
+	Fetchers        []ImageFetcher
+	WaitGroup       *sync.WaitGroup
+	Config          ImageSource
+	Name            string
+	ImageBasePath   string
+	TransferManager *TransferManager
+	Ctx             context.Context
+	Cancel          context.CancelFunc
 }
 
 type ImageFetcher interface {
@@ -62,13 +216,38 @@ type ImageFetcher interface {
 	GetName() string
 	GetImageURL() (string, error)
 	GetErrorChannel() *chan error
+	// GetDistro, GetRelease and GetArchitecture expose the components
+	// GetName folds together, for callers (ImageUploadHandler's state-aware
+	// FilterFetchers) that need to key per-backend state or look up the
+	// configured upload targets for this fetcher's distro/release.
+	GetDistro() string
+	GetRelease() string
+	GetArchitecture() string
+	// ExpectedChecksum returns the upstream-published digest algorithm
+	// ("sha256", "sha512") and hex-encoded digest the downloaded image must
+	// match before it's trusted.
+	ExpectedChecksum() (algo string, hexDigest string, err error)
+	ExpectedSize() (int64, error)
+	// UpstreamSerial returns the version/build identifier upstream reports
+	// for the current image (a simplestreams serial, a Last-Modified date, ...),
+	// used to version the uploaded image's name and to detect rebuilds
+	// without re-downloading.
+	UpstreamSerial() (string, error)
 }
 
+// BaseImageFetcher implements the download+verify machinery shared by every
+// distro fetcher. Self must be set to the concrete fetcher embedding this
+// struct so Fetch can call its distro-specific ExpectedChecksum/ExpectedSize
+// overrides: Go embedding doesn't dispatch virtually, so without Self, Fetch
+// would only ever see BaseImageFetcher's own (nonexistent) implementation.
 type BaseImageFetcher struct {
 	ImageBasePath, Name, Release, Architecture string
 	ErrorChannel                               *chan error
 	ImagesChannel                              *chan Image
 	WaitGroup                                  *sync.WaitGroup
+	TransferManager                            *TransferManager
+	Ctx                                        context.Context
+	Self                                       ImageFetcher
 }
 
 func (fetcher *BaseImageFetcher) GetErrorChannel() *chan error {
@@ -79,60 +258,117 @@ func (fetcher *BaseImageFetcher) GetName() string {
 	return fmt.Sprintf("%s-%s-%s", fetcher.Name, fetcher.Release, fetcher.Architecture)
 }
 
+func (fetcher *BaseImageFetcher) GetDistro() string       { return fetcher.Name }
+func (fetcher *BaseImageFetcher) GetRelease() string      { return fetcher.Release }
+func (fetcher *BaseImageFetcher) GetArchitecture() string { return fetcher.Architecture }
+
 func (fetcher *BaseImageFetcher) Cleanup() error {
 	log.Infof("Cleaning up base image directory: %s for fetcher: %s", fetcher.ImageBasePath, fetcher.GetName())
 	return os.RemoveAll(fetcher.ImageBasePath)
 }
 
+// Fetch submits imageURL to the shared TransferManager and waits for it to
+// land on disk, logging progress as it comes in. The manager owns
+// deduplication, chunking, retries and resume, so Fetch starts no goroutine
+// of its own. A failed transfer leaves its partial file in ImageBasePath;
+// Fetch does not remove it. Once the transfer completes, the file is verified against
+// Self.ExpectedChecksum()/ExpectedSize() before being handed off; a mismatch
+// deletes the file and is reported as an error instead of reaching
+// ImagesChannel.
 func (fetcher *BaseImageFetcher) Fetch(imageURL string, errChannel *chan error) {
-	var image Image
-
 	defer fetcher.WaitGroup.Done()
 
-	image = Image{Release: fetcher.Release, Distro: fetcher.Name, Architecture: fetcher.Architecture}
-
 	log.Infof("Downloading image: %s", imageURL)
-	req, err := http.NewRequest("GET", imageURL, nil)
+
+	for event := range fetcher.TransferManager.Submit(fetcher.Ctx, imageURL, fetcher.ImageBasePath) {
+		if event.Err != nil {
+			*errChannel <- event.Err
+			return
+		}
+
+		if !event.Done {
+			log.Debugf("%s: %d/%d bytes", fetcher.GetName(), event.Received, event.Total)
+			continue
+		}
+
+		if err := fetcher.verify(event.File); err != nil {
+			event.File.Close()
+			os.Remove(event.File.Name())
+			*errChannel <- fmt.Errorf("%s: %s", fetcher.GetName(), err)
+			return
+		}
+
+		algo, digest, err := fetcher.Self.ExpectedChecksum()
+		if err != nil {
+			event.File.Close()
+			os.Remove(event.File.Name())
+			*errChannel <- fmt.Errorf("%s: %s", fetcher.GetName(), err)
+			return
+		}
+
+		serial, err := fetcher.Self.UpstreamSerial()
+		if err != nil {
+			log.Warnf("%s: could not determine upstream serial: %s", fetcher.GetName(), err)
+		}
+
+		*fetcher.ImagesChannel <- Image{
+			Release:              fetcher.Release,
+			Distro:               fetcher.Name,
+			Architecture:         fetcher.Architecture,
+			File:                 event.File,
+			UpstreamURL:          imageURL,
+			UpstreamChecksumAlgo: algo,
+			UpstreamChecksum:     digest,
+			Serial:               serial,
+		}
+	}
+}
+
+// verify streams file through the digest algorithm Self reports and checks
+// both the byte count and the resulting digest against Self's expectations,
+// rewinding file back to the start on success so downstream readers see the
+// whole image.
+func (fetcher *BaseImageFetcher) verify(file *os.File) error {
+	algo, expectedDigest, err := fetcher.Self.ExpectedChecksum()
 	if err != nil {
-		*errChannel <- err
-		return
+		return errors.Annotate(err, "fetching expected checksum")
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	expectedSize, err := fetcher.Self.ExpectedSize()
 	if err != nil {
-		*errChannel <- err
-		return
+		return errors.Annotate(err, "fetching expected size")
 	}
 
-	defer func() {
-		resp.Body.Close()
-	}()
+	hasher, err := newHasher(algo)
+	if err != nil {
+		return err
+	}
 
-	if resp.StatusCode != 200 {
-		*errChannel <- fmt.Errorf(resp.Status)
-		return
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
 	}
 
-	hash := sha256.New()
-	image.File, err = ioutil.TempFile(fetcher.ImageBasePath, "image")
+	written, err := io.Copy(hasher, file)
 	if err != nil {
-		*errChannel <- err
-		return
+		return err
 	}
 
-	writer := io.MultiWriter(image.File, hash)
-	_, err = io.Copy(writer, resp.Body)
-	if err != nil {
-		*errChannel <- err
-		return
+	if expectedSize > 0 && written != expectedSize {
+		return fmt.Errorf("size mismatch: downloaded %d bytes, expected %d", written, expectedSize)
+	}
+
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	if !strings.EqualFold(digest, expectedDigest) {
+		return fmt.Errorf("%s checksum mismatch: downloaded %s, expected %s", algo, digest, expectedDigest)
 	}
 
-	*fetcher.ImagesChannel <- image
+	_, err = file.Seek(0, io.SeekStart)
+	return err
 }
 
 type DebianImageFetcher struct {
 	BaseImageFetcher
+	checksums map[string]string // filename -> hex sha512, from a verified SHA512SUMS
 }
 
 const (
@@ -147,7 +383,73 @@ func (fetcher *DebianImageFetcher) GetImageURL() (string, error) {
 	return fmt.Sprintf("%s/%s/debian-%s-openstack-%s.qcow2", DebianBaseOpenstackImagesURL, fetcher.Release, DebianReleaseMap[fetcher.Release], fetcher.Architecture), nil
 }
 
-func NewDebianImageFetcher(release, architecture, basepath string, wg *sync.WaitGroup, imagesChannel *chan Image, errorChannel *chan error) (*DebianImageFetcher, error) {
+func (fetcher *DebianImageFetcher) imageFileName() string {
+	return fmt.Sprintf("debian-%s-openstack-%s.qcow2", DebianReleaseMap[fetcher.Release], fetcher.Architecture)
+}
+
+// loadChecksums fetches SHA512SUMS and its detached SHA512SUMS.sign from the
+// same directory as the image, verifies the signature against the Debian CD
+// signing key read from the file named by DEBIAN_CD_SIGNING_KEY_FILE (no key
+// is built in) and caches the parsed digests. Debian ships no
+// per-release manifest beyond this, unlike Ubuntu's signed simplestreams.
+func (fetcher *DebianImageFetcher) loadChecksums() error {
+	if fetcher.checksums != nil {
+		return nil
+	}
+
+	dir := fmt.Sprintf("%s/%s", DebianBaseOpenstackImagesURL, fetcher.Release)
+
+	sums, err := httpGetBytes(dir + "/SHA512SUMS")
+	if err != nil {
+		return errors.Annotate(err, "fetching SHA512SUMS")
+	}
+
+	signature, err := httpGetBytes(dir + "/SHA512SUMS.sign")
+	if err != nil {
+		return errors.Annotate(err, "fetching SHA512SUMS.sign")
+	}
+
+	if err := verifyDebianSignature(sums, signature); err != nil {
+		return errors.Annotate(err, "verifying SHA512SUMS signature")
+	}
+
+	fetcher.checksums = parseChecksumManifest(sums)
+	return nil
+}
+
+func (fetcher *DebianImageFetcher) ExpectedChecksum() (string, string, error) {
+	if err := fetcher.loadChecksums(); err != nil {
+		return "", "", err
+	}
+
+	digest, ok := fetcher.checksums[fetcher.imageFileName()]
+	if !ok {
+		return "", "", fmt.Errorf("no SHA512SUMS entry for %s", fetcher.imageFileName())
+	}
+
+	return "sha512", digest, nil
+}
+
+func (fetcher *DebianImageFetcher) ExpectedSize() (int64, error) {
+	imageURL, err := fetcher.GetImageURL()
+	if err != nil {
+		return 0, err
+	}
+	return httpContentLength(imageURL)
+}
+
+// UpstreamSerial uses the image's HTTP Last-Modified date as a stand-in for
+// a release serial: Debian's openstack images carry no simplestreams-style
+// version number of their own.
+func (fetcher *DebianImageFetcher) UpstreamSerial() (string, error) {
+	imageURL, err := fetcher.GetImageURL()
+	if err != nil {
+		return "", err
+	}
+	return httpLastModifiedSerial(imageURL)
+}
+
+func NewDebianImageFetcher(release, architecture, basepath string, wg *sync.WaitGroup, imagesChannel *chan Image, errorChannel *chan error, transferManager *TransferManager, ctx context.Context) (*DebianImageFetcher, error) {
 	dir, err := ioutil.TempDir(basepath, "debian")
 	if err != nil {
 		return nil, err
@@ -161,17 +463,21 @@ func NewDebianImageFetcher(release, architecture, basepath string, wg *sync.Wait
 		release = DebianTestingRelease
 	}
 
-	return &DebianImageFetcher{BaseImageFetcher{
-		ErrorChannel:  errorChannel,
-		ImagesChannel: imagesChannel,
-		WaitGroup:     wg,
-		ImageBasePath: dir, Name: "debian", Architecture: architecture, Release: release},
-	}, nil
-
+	fetcher := &DebianImageFetcher{BaseImageFetcher: BaseImageFetcher{
+		ErrorChannel:    errorChannel,
+		ImagesChannel:   imagesChannel,
+		WaitGroup:       wg,
+		TransferManager: transferManager,
+		Ctx:             ctx,
+		ImageBasePath:   dir, Name: "debian", Architecture: architecture, Release: release},
+	}
+	fetcher.Self = fetcher
+	return fetcher, nil
 }
 
 type UbuntuImageFetcher struct {
 	BaseImageFetcher
+	metadata *imagedownloads.Metadata // cached result of the simplestreams lookup, carries SHA256+Size
 }
 
 const (
@@ -181,7 +487,11 @@ const (
 	UbuntuImagesBaseURL = imagemetadata.UbuntuCloudImagesURL + "/" + imagemetadata.ReleasedImagesPath
 )
 
-func (fetcher *UbuntuImageFetcher) GetImageURL() (string, error) {
+func (fetcher *UbuntuImageFetcher) lookupMetadata() (*imagedownloads.Metadata, error) {
+	if fetcher.metadata != nil {
+		return fetcher.metadata, nil
+	}
+
 	var ftype = BIOSFType
 
 	if fetcher.Architecture == "arm64" {
@@ -202,6 +512,16 @@ func (fetcher *UbuntuImageFetcher) GetImageURL() (string, error) {
 		},
 	)
 
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher.metadata = metadata
+	return metadata, nil
+}
+
+func (fetcher *UbuntuImageFetcher) GetImageURL() (string, error) {
+	metadata, err := fetcher.lookupMetadata()
 	if err != nil {
 		return "", err
 	}
@@ -214,7 +534,34 @@ func (fetcher *UbuntuImageFetcher) GetImageURL() (string, error) {
 	return url.String(), nil
 }
 
-func NewUbuntuImageFetcher(release, architecture, basepath string, wg *sync.WaitGroup, imagesChannel *chan Image, errorChannel *chan error) (*UbuntuImageFetcher, error) {
+func (fetcher *UbuntuImageFetcher) ExpectedChecksum() (string, string, error) {
+	metadata, err := fetcher.lookupMetadata()
+	if err != nil {
+		return "", "", err
+	}
+	return "sha256", metadata.SHA256, nil
+}
+
+func (fetcher *UbuntuImageFetcher) ExpectedSize() (int64, error) {
+	metadata, err := fetcher.lookupMetadata()
+	if err != nil {
+		return 0, err
+	}
+	return metadata.Size, nil
+}
+
+// UpstreamSerial returns the simplestreams serial (e.g. "20240115") the
+// metadata was published under, so the uploaded image's name changes across
+// daily rebuilds even when the architecture/release don't.
+func (fetcher *UbuntuImageFetcher) UpstreamSerial() (string, error) {
+	metadata, err := fetcher.lookupMetadata()
+	if err != nil {
+		return "", err
+	}
+	return metadata.Version, nil
+}
+
+func NewUbuntuImageFetcher(release, architecture, basepath string, wg *sync.WaitGroup, imagesChannel *chan Image, errorChannel *chan error, transferManager *TransferManager, ctx context.Context) (*UbuntuImageFetcher, error) {
 	dir, err := ioutil.TempDir(basepath, "ubuntu")
 	if err != nil {
 		return nil, err
@@ -224,21 +571,28 @@ func NewUbuntuImageFetcher(release, architecture, basepath string, wg *sync.Wait
 		release = UbuntuLatestRelease
 	}
 
-	return &UbuntuImageFetcher{BaseImageFetcher{
-		ErrorChannel:  errorChannel,
-		ImagesChannel: imagesChannel,
-		WaitGroup:     wg,
-		ImageBasePath: dir, Name: "ubuntu", Architecture: architecture, Release: release},
-	}, nil
+	fetcher := &UbuntuImageFetcher{BaseImageFetcher: BaseImageFetcher{
+		ErrorChannel:    errorChannel,
+		ImagesChannel:   imagesChannel,
+		WaitGroup:       wg,
+		TransferManager: transferManager,
+		Ctx:             ctx,
+		ImageBasePath:   dir, Name: "ubuntu", Architecture: architecture, Release: release},
+	}
+	fetcher.Self = fetcher
+	return fetcher, nil
 }
 
-func NewImageFetcher(distro, release, architecture, basepath string, wg *sync.WaitGroup, imagesChannel *chan Image, errorChannel *chan error) (ImageFetcher, error) {
+func NewImageFetcher(distro, release, architecture, basepath string, wg *sync.WaitGroup, imagesChannel *chan Image, errorChannel *chan error, transferManager *TransferManager, ctx context.Context, releaseConfig Release) (ImageFetcher, error) {
 	switch distro {
 		case "ubuntu": {
-			return NewUbuntuImageFetcher(release, architecture, basepath, wg, imagesChannel, errorChannel)
+			return NewUbuntuImageFetcher(release, architecture, basepath, wg, imagesChannel, errorChannel, transferManager, ctx)
 		}
 		case "debian": {
-			return NewDebianImageFetcher(release, architecture, basepath, wg, imagesChannel, errorChannel)
+			return NewDebianImageFetcher(release, architecture, basepath, wg, imagesChannel, errorChannel, transferManager, ctx)
+		}
+		case "ubuntu-core": {
+			return NewUbuntuCoreImageFetcher(release, architecture, basepath, wg, imagesChannel, errorChannel, transferManager, ctx, releaseConfig.Track, releaseConfig.Channel, releaseConfig.Model)
 		}
 	}
 	return nil, fmt.Errorf("Not found handler for: %s", distro)
@@ -260,11 +614,13 @@ func NewImageFetcherHandler(config ImageSource, errChannel *chan error) (*ImageF
 	handler.WaitGroup = &sync.WaitGroup{}
 	handler.ImagesChannel = &imageChannel
 	handler.ErrorChannel = errChannel
+	handler.TransferManager = NewTransferManager(DefaultTransferWorkers, DefaultChunkSize, DefaultMaxRetries)
+	handler.Ctx, handler.Cancel = context.WithCancel(context.Background())
 
 	for distro, config := range config.DistroSources {
 		for release, releaseConfig := range config.Releases {
 			for _, architecture := range releaseConfig.Architectures {
-				fetcher, err := NewImageFetcher(distro, release, architecture, handler.ImageBasePath, handler.WaitGroup, handler.ImagesChannel, handler.ErrorChannel)
+				fetcher, err := NewImageFetcher(distro, release, architecture, handler.ImageBasePath, handler.WaitGroup, handler.ImagesChannel, handler.ErrorChannel, handler.TransferManager, handler.Ctx, releaseConfig)
 				if err != nil {
 					return nil, err
 				}
@@ -277,6 +633,7 @@ func NewImageFetcherHandler(config ImageSource, errChannel *chan error) (*ImageF
 }
 
 func (handler *ImageFetchHandler) Cleanup() {
+	handler.Cancel()
 	for _, fetcher := range handler.Fetchers {
 		fetcher.Cleanup()
 	}
@@ -327,16 +684,27 @@ func main() {
 		panic(err)
 	}
 
-	uploader, err := NewImageUploadHandler("uk.linaro.cloud", "/home/niedbalski/.config/openstack/clouds.yml", &errChannel)
+	postProcessor, err := NewPostProcessHandler(config.ImageSources, &errChannel)
+	if err != nil {
+		panic(err)
+	}
+
+	uploader, err := NewImageUploadHandler("uk.linaro.cloud", "/home/niedbalski/.config/openstack/clouds.yml", config.ImageSources, &errChannel)
 	if err != nil {
 		panic(err)
 	}
 
+	var processedChannel chan Image
+	processedChannel = make(chan Image)
+
 	// Fetch new images
-	go fetcher.Handle(uploader.Uploader.FilterFetchers)
+	go fetcher.Handle(uploader.FilterFetchers)
+
+	// Run each fetched image through its configured post-processing pipeline
+	go postProcessor.Handle(fetcher.ImagesChannel, &processedChannel)
 
-	// Wait for new images to be uploaded into glance
-	go uploader.Handle(fetcher.ImagesChannel)
+	// Wait for post-processed images to be uploaded
+	go uploader.Handle(&processedChannel)
 
 	c := make(chan os.Signal)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
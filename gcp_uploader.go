@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"cloud.google.com/go/storage"
+	compute "cloud.google.com/go/compute/apiv1"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/option"
+)
+
+// GCPUploadOptions is the per-release "gcp" upload target config.
+type GCPUploadOptions struct {
+	Bucket         string `yaml:"bucket"`
+	Project        string `yaml:"project"`
+	Family         string `yaml:"family"`
+	CredentialsFile string `yaml:"credentials_file"`
+}
+
+// GCPImageUploader uploads the raw-converted image as a tarball to a GCS
+// bucket and registers a GCE image from it.
+type GCPImageUploader struct {
+	Options        GCPUploadOptions
+	StorageClient  *storage.Client
+	ImagesClient   *compute.ImagesClient
+}
+
+func NewGCPImageUploader(ctx context.Context, options GCPUploadOptions) (*GCPImageUploader, error) {
+	opts := []option.ClientOption{}
+	if options.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(options.CredentialsFile))
+	}
+
+	storageClient, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	imagesClient, err := compute.NewImagesRESTClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GCPImageUploader{Options: options, StorageClient: storageClient, ImagesClient: imagesClient}, nil
+}
+
+// imageName folds the upstream serial into the uploaded image's name, the
+// same way GlanceImageUploader.imageName does, so a rebuild of the same
+// release/arch gets a distinct name instead of colliding with the previous
+// Images.Insert call.
+func (uploader *GCPImageUploader) imageName(image *Image) string {
+	base := fmt.Sprintf("%s-%s-%s", image.Distro, image.Release, image.Architecture)
+	if image.Serial == "" {
+		return base
+	}
+	return fmt.Sprintf("%s-%s", base, image.Serial)
+}
+
+func (uploader *GCPImageUploader) Name() string { return "gcp" }
+
+// Upload converts the image to a raw disk.raw tarball, uploads it to GCS
+// and inserts a GCE image referencing it.
+func (uploader *GCPImageUploader) Upload(ctx context.Context, image *Image) (*UploadResult, error) {
+	imageName := uploader.imageName(image)
+	log.Infof("Uploading image:%s to GCP project %s", imageName, uploader.Options.Project)
+
+	rawFile, err := convertImageFormat(ctx, image.File.Name(), "raw")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(rawFile)
+
+	tarFile, err := tarGzipRawDisk(rawFile)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tarFile)
+
+	f, err := os.Open(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	objectName := fmt.Sprintf("%s.tar.gz", imageName)
+	writer := uploader.StorageClient.Bucket(uploader.Options.Bucket).Object(objectName).NewWriter(ctx)
+	if _, err := writer.ReadFrom(f); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	sourceURI := fmt.Sprintf("https://storage.googleapis.com/%s/%s", uploader.Options.Bucket, objectName)
+	op, err := uploader.ImagesClient.Insert(ctx, &computepb.InsertImageRequest{
+		Project: uploader.Options.Project,
+		ImageResource: &computepb.Image{
+			Name:   &imageName,
+			Family: &uploader.Options.Family,
+			RawDisk: &computepb.RawDisk{
+				Source: &sourceURI,
+			},
+			GuestOsFeatures: []*computepb.GuestOsFeature{
+				{Type: computepb.GuestOsFeature_UEFI_COMPATIBLE.Enum()},
+				{Type: computepb.GuestOsFeature_VIRTIO_SCSI_MULTIQUEUE.Enum()},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := op.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return &UploadResult{Backend: "gcp", ID: imageName, Location: uploader.Options.Project}, nil
+}
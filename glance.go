@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/niedbalski/goose.v3/client"
@@ -10,8 +11,9 @@ import (
 )
 
 type GlanceImageUploader struct {
-	Config          *Cloud
-	Client          *glance.Client
+	Config *Cloud
+	Client *glance.Client
+	State  *StateStore
 }
 
 type ImageUploadResult struct {
@@ -19,7 +21,7 @@ type ImageUploadResult struct {
 	Image *glance.CreateImageResponse
 }
 
-func NewGlanceImageUploader(cloudName string, cloudConfigPath string) (*GlanceImageUploader, error) {
+func NewGlanceImageUploader(cloudName string, cloudConfigPath string, state *StateStore) (*GlanceImageUploader, error) {
 	var credentials identity.Credentials
 	var newClient client.AuthenticatingClient
 
@@ -50,58 +52,64 @@ func NewGlanceImageUploader(cloudName string, cloudConfigPath string) (*GlanceIm
 	newClient.SetRequiredServiceTypes([]string{"image"})
 	newClient.Authenticate()
 
-	return &GlanceImageUploader{Config: config, Client: glance.New(newClient)}, nil
+	return &GlanceImageUploader{Config: config, Client: glance.New(newClient), State: state}, nil
 }
 
-func (uploader *GlanceImageUploader) HasImage(imageName string) bool {
-	images, err := uploader.Client.ListImagesV2()
-	if err != nil {
-		return false
-	}
+func (uploader *GlanceImageUploader) Name() string { return "glance" }
 
-	for _, image := range images {
-		if image.Name == imageName {
-			return true
-		}
+// imageName folds the upstream serial into the uploaded image's name (e.g.
+// "ubuntu-jammy-amd64-20240115") so rebuilds of the same release/arch don't
+// collide in Glance instead of replacing one another.
+func (uploader *GlanceImageUploader) imageName(image *Image) string {
+	base := fmt.Sprintf("%s-%s-%s", image.Distro, image.Release, image.Architecture)
+	if image.Serial == "" {
+		return base
 	}
-	return false
+	return fmt.Sprintf("%s-%s", base, image.Serial)
 }
 
-func (uploader *GlanceImageUploader) FilterFetchers(fetchers []ImageFetcher) []ImageFetcher {
-	var filtered []ImageFetcher
-	for _, fetcher := range fetchers {
-		if !uploader.HasImage(fetcher.GetName()) {
-			log.Infof("Adding %s to the list of images to fetch", fetcher.GetName())
-			filtered = append(filtered, fetcher)
-		}
-	}
-	log.Infof("Found %d new images to fetch", len(filtered))
-	return filtered
-}
+// Upload creates a new Glance image, points it at the prior image (if any)
+// via image_property:previous_image_id and marks that prior image community
+// so operators can roll back. ImageUploadHandler.Handle records the
+// resulting state after Upload returns; Upload itself only needs to read
+// the previous record.
+func (uploader *GlanceImageUploader) Upload(ctx context.Context, image *Image) (*UploadResult, error) {
+	imageName := uploader.imageName(image)
+	previous, hadPrevious := uploader.State.Get(stateKey(uploader.Name(), fmt.Sprintf("%s-%s-%s", image.Distro, image.Release, image.Architecture)))
 
-func (uploader *GlanceImageUploader) Upload(image *Image, errChannel *chan error) {
-	imageName := fmt.Sprintf("%s-%s-%s", image.Distro, image.Release, image.Architecture)
 	log.Infof("Uploading image:%s to glance", imageName)
 
 	file, err := os.Open(image.File.Name())
 	if err != nil {
-		*errChannel <- err
-		return
+		return nil, err
 	}
 
 	defer file.Close()
 
-	uploadedImage, err := uploader.Client.CreateImageFromFile(file, glance.ImageOpts{
+	opts := glance.ImageOpts{
 		Name:            imageName,
 		DiskFormat:      "qcow2",
 		ContainerFormat: "bare",
 		Visibility:      "public",
-	})
+	}
+	if hadPrevious && previous.UploadedID != "" {
+		opts.Properties = map[string]string{"previous_image_id": previous.UploadedID}
+	}
 
+	uploadedImage, err := uploader.Client.CreateImageFromFile(file, opts)
 	if err != nil {
-		*errChannel <- err
-		return
+		return nil, err
+	}
+
+	log.Infof("Image name: %s, ID: %s - uploaded to glance at %s", uploadedImage.Name, uploadedImage.ID, uploadedImage.UpdatedAt)
+
+	if hadPrevious && previous.UploadedID != "" {
+		if err := uploader.Client.UpdateImageV2(previous.UploadedID, []glance.Patch{
+			{Op: "replace", Path: "/visibility", Value: "community"},
+		}); err != nil {
+			log.Warnf("marking previous image %s community: %s", previous.UploadedID, err)
+		}
 	}
 
-	log.Info("Image name: %s, ID: %s - uploaded to glance at %s", uploadedImage.ID, uploadedImage.Name, uploadedImage.UpdatedAt)
+	return &UploadResult{Backend: "glance", ID: uploadedImage.ID, Location: imageName}, nil
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// convertImageFormat shells out to qemu-img to convert the image at path
+// into targetFormat (e.g. "raw", or "vhd" for Azure's fixed VHD, written by
+// qemu-img's vpc driver with subformat=fixed), writing the result next to
+// path and returning the new file's name. The caller owns the returned file
+// and is responsible for removing it.
+func convertImageFormat(ctx context.Context, path, targetFormat string) (string, error) {
+	dst, err := ioutil.TempFile(filepath.Dir(path), "image-"+targetFormat)
+	if err != nil {
+		return "", err
+	}
+	dst.Close()
+
+	args := []string{"convert", "-O", targetFormat}
+	if targetFormat == "vhd" {
+		args = []string{"convert", "-O", "vpc", "-o", "subformat=fixed"}
+	}
+	args = append(args, path, dst.Name())
+
+	cmd := exec.CommandContext(ctx, "qemu-img", args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(dst.Name())
+		return "", fmt.Errorf("qemu-img convert to %s failed: %s: %s", targetFormat, err, out)
+	}
+
+	return dst.Name(), nil
+}
+
+// tempFileNextTo creates an empty temp file in the same directory as path,
+// for processors that write their output before swapping it in for path.
+func tempFileNextTo(path, prefix string) (string, error) {
+	dst, err := ioutil.TempFile(filepath.Dir(path), prefix)
+	if err != nil {
+		return "", err
+	}
+	name := dst.Name()
+	dst.Close()
+	return name, nil
+}
+
+// tarGzipRawDisk packages a raw disk image as "disk.raw" inside a gzipped
+// tarball, the layout GCE's Images.Insert expects for rawDisk.source.
+func tarGzipRawDisk(rawPath string) (string, error) {
+	src, err := os.Open(rawPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	dst, err := ioutil.TempFile(filepath.Dir(rawPath), "image-tar")
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	gzw := gzip.NewWriter(dst)
+	tw := tar.NewWriter(gzw)
+
+	if err := tw.WriteHeader(&tar.Header{Name: "disk.raw", Size: info.Size(), Mode: 0644}); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	if _, err := io.Copy(tw, src); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	if err := tw.Close(); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	if err := gzw.Close(); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+
+	return dst.Name(), nil
+}
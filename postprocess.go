@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/exec"
+)
+
+// PostProcessor transforms a fetched Image before it's handed to the
+// upload backends, e.g. converting its format or injecting a cloud-init
+// datasource. Implementations return an *Image pointing at the transformed
+// file: a new file, or the same one for processors that work in place. When
+// the file changed, the pipeline takes care of closing/removing the
+// previous one.
+type PostProcessor interface {
+	Name() string
+	Process(ctx context.Context, image *Image) (*Image, error)
+}
+
+// PostProcessPipeline runs an ordered list of PostProcessors over an Image,
+// replacing Image.File in place after each step and recording that step's
+// sha256 on the result.
+type PostProcessPipeline struct {
+	Processors []PostProcessor
+}
+
+func (pipeline *PostProcessPipeline) Process(ctx context.Context, image *Image) (*Image, error) {
+	current := image
+
+	for _, processor := range pipeline.Processors {
+		next, err := processor.Process(ctx, current)
+		if err != nil {
+			return nil, fmt.Errorf("post-process %s: %s", processor.Name(), err)
+		}
+
+		if next.File != current.File {
+			current.File.Close()
+			os.Remove(current.File.Name())
+		}
+
+		digest, err := fileSHA256(next.File.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		next.Steps = append(next.Steps, ProcessingStep{Processor: processor.Name(), SHA256: digest})
+		current = next
+	}
+
+	return current, nil
+}
+
+// FormatConverter converts Image.File to TargetFormat via qemu-img convert,
+// needed because AWS wants raw and Azure wants a fixed VHD, not qcow2.
+type FormatConverter struct {
+	TargetFormat string
+}
+
+func (c *FormatConverter) Name() string { return fmt.Sprintf("convert:%s", c.TargetFormat) }
+
+func (c *FormatConverter) Process(ctx context.Context, image *Image) (*Image, error) {
+	converted, err := convertImageFormat(ctx, image.File.Name(), c.TargetFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(converted)
+	if err != nil {
+		return nil, err
+	}
+
+	out := *image
+	out.File = file
+	return &out, nil
+}
+
+// Sparsifier rewrites Image.File as a compressed, zero-deduplicated qcow2,
+// shrinking the artifact before it's staged for upload.
+type Sparsifier struct{}
+
+func (s *Sparsifier) Name() string { return "sparsify" }
+
+func (s *Sparsifier) Process(ctx context.Context, image *Image) (*Image, error) {
+	dst, err := tempFileNextTo(image.File.Name(), "sparsify")
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, "qemu-img", "convert", "-O", "qcow2", "-c", image.File.Name(), dst)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(dst)
+		return nil, fmt.Errorf("qemu-img sparsify failed: %s: %s", err, out)
+	}
+
+	file, err := os.Open(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	out := *image
+	out.File = file
+	return &out, nil
+}
+
+// Resizer grows or shrinks Image.File's virtual disk size by Size (a
+// qemu-img resize argument, e.g. "+2G"). It resizes in place: no new file is
+// produced.
+type Resizer struct {
+	Size string
+}
+
+func (r *Resizer) Name() string { return fmt.Sprintf("resize:%s", r.Size) }
+
+func (r *Resizer) Process(ctx context.Context, image *Image) (*Image, error) {
+	cmd := exec.CommandContext(ctx, "qemu-img", "resize", image.File.Name(), r.Size)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("qemu-img resize failed: %s: %s", err, out)
+	}
+	return image, nil
+}
+
+// CloudInitInjector mounts Image.File with guestfish and drops a
+// /etc/cloud/cloud.cfg.d/ datasource snippet (and, if set, a NoCloud
+// meta-data/user-data seed) into it, in place.
+type CloudInitInjector struct {
+	CloudCfgSnippet    string // local path to a cloud.cfg.d/*.cfg file to upload
+	MetaData, UserData string // local paths to a NoCloud seed's meta-data/user-data
+}
+
+func (c *CloudInitInjector) Name() string { return "cloud-init-inject" }
+
+func (c *CloudInitInjector) Process(ctx context.Context, image *Image) (*Image, error) {
+	args := []string{"-a", image.File.Name(), "-i"}
+
+	// Each "upload src dst" is its own guestfish command; guestfish requires
+	// a literal ":" between commands passed as positional args, or it parses
+	// them as one command with too many parameters.
+	var commands [][]string
+	if c.CloudCfgSnippet != "" {
+		commands = append(commands, []string{"upload", c.CloudCfgSnippet, "/etc/cloud/cloud.cfg.d/99-openstack-cloudimage-sync.cfg"})
+	}
+	if c.MetaData != "" {
+		commands = append(commands, []string{"upload", c.MetaData, "/var/lib/cloud/seed/nocloud/meta-data"})
+	}
+	if c.UserData != "" {
+		commands = append(commands, []string{"upload", c.UserData, "/var/lib/cloud/seed/nocloud/user-data"})
+	}
+
+	if len(commands) == 0 {
+		return image, nil
+	}
+
+	for i, command := range commands {
+		if i > 0 {
+			args = append(args, ":")
+		}
+		args = append(args, command...)
+	}
+
+	cmd := exec.CommandContext(ctx, "guestfish", args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("guestfish injection failed: %s: %s", err, out)
+	}
+
+	return image, nil
+}
+
+func newPostProcessor(config PostProcessorConfig) (PostProcessor, error) {
+	switch config.Type {
+	case "convert":
+		return &FormatConverter{TargetFormat: config.Format}, nil
+	case "sparsify":
+		return &Sparsifier{}, nil
+	case "resize":
+		return &Resizer{Size: config.Size}, nil
+	case "cloud-init":
+		return &CloudInitInjector{CloudCfgSnippet: config.CloudCfg, MetaData: config.MetaData, UserData: config.UserData}, nil
+	}
+	return nil, fmt.Errorf("unknown post-process type: %s", config.Type)
+}
+
+// PostProcessHandler runs every fetched Image through the PostProcessPipeline
+// configured for its distro/release before forwarding it on to the out
+// channel given to Handle, sitting between ImageFetchHandler and
+// ImageUploadHandler.
+type PostProcessHandler struct {
+	ErrorChannel *chan error
+	Pipelines    map[string]*PostProcessPipeline
+}
+
+func NewPostProcessHandler(config ImageSource, errChannel *chan error) (*PostProcessHandler, error) {
+	pipelines := make(map[string]*PostProcessPipeline)
+
+	for distro, distroConfig := range config.DistroSources {
+		for release, releaseConfig := range distroConfig.Releases {
+			var processors []PostProcessor
+			for _, processorConfig := range releaseConfig.PostProcessors {
+				processor, err := newPostProcessor(processorConfig)
+				if err != nil {
+					return nil, err
+				}
+				processors = append(processors, processor)
+			}
+			pipelines[targetsKey(distro, release)] = &PostProcessPipeline{Processors: processors}
+		}
+	}
+
+	return &PostProcessHandler{ErrorChannel: errChannel, Pipelines: pipelines}, nil
+}
+
+func (handler *PostProcessHandler) Handle(in *chan Image, out *chan Image) {
+	for {
+		select {
+		case image := <-*in:
+			{
+				go func(image Image) {
+					pipeline, ok := handler.Pipelines[targetsKey(image.Distro, image.Release)]
+					if !ok || len(pipeline.Processors) == 0 {
+						*out <- image
+						return
+					}
+
+					log.Infof("Post-processing %s-%s-%s through %d step(s)", image.Distro, image.Release, image.Architecture, len(pipeline.Processors))
+
+					processed, err := pipeline.Process(context.Background(), &image)
+					if err != nil {
+						*handler.ErrorChannel <- err
+						return
+					}
+
+					*out <- *processed
+				}(image)
+			}
+		}
+	}
+}
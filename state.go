@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultStateFile is used when a Config doesn't set ImageSource.StateFile.
+const DefaultStateFile = "./image-sync-state.json"
+
+// ImageState is what StateStore remembers about the last image uploaded to
+// one backend for one fetcher, keyed by stateKey(backend, fetcherKey). It
+// holds enough of the upstream's own identity (URL, checksum, serial) to
+// tell a rebuild apart from an unchanged image without re-downloading, plus
+// the uploaded side's id (a Glance image ID, an AMI id, ...) to support
+// rollback where the backend supports it.
+type ImageState struct {
+	UpstreamURL    string
+	DigestAlgo     string
+	Digest         string
+	UpstreamSerial string
+	UploadedID     string
+	UpdatedAt      time.Time
+}
+
+// stateKey scopes an ImageState record to one backend, so a release
+// uploaded to more than one target (e.g. both "aws" and "glance") gets an
+// independent record per backend instead of the backends clobbering each
+// other's state.
+func stateKey(backend, fetcherKey string) string {
+	return fmt.Sprintf("%s/%s", backend, fetcherKey)
+}
+
+// StateStore is a JSON-file-backed map of ImageState. Sync runs as a single
+// long-lived process managing a handful of images, so writing the whole file
+// out on every update is simplest and cheap enough; there's no need for a
+// real database.
+type StateStore struct {
+	path   string
+	mu     sync.Mutex
+	Images map[string]ImageState
+}
+
+// NewStateStore loads path if it exists, or starts empty if it doesn't - the
+// first run of a fresh sync has nothing to compare against yet.
+func NewStateStore(path string) (*StateStore, error) {
+	store := &StateStore{path: path, Images: make(map[string]ImageState)}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &store.Images); err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %s", path, err)
+	}
+
+	return store, nil
+}
+
+// Get looks up the recorded state for key, as built by stateKey.
+func (store *StateStore) Get(key string) (ImageState, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	state, ok := store.Images[key]
+	return state, ok
+}
+
+// Put records state under key and persists the store to disk.
+func (store *StateStore) Put(key string, state ImageState) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.Images[key] = state
+	return store.persist()
+}
+
+func (store *StateStore) persist() error {
+	data, err := json.MarshalIndent(store.Images, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(store.path, data, 0644)
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultChunkSize       = 32 * 1024 * 1024 // 32MiB range per worker
+	DefaultTransferWorkers = 4
+	DefaultMaxRetries      = 5
+)
+
+// TransferEvent reports progress or the final outcome of a submitted
+// download back to the caller of TransferManager.Submit.
+type TransferEvent struct {
+	URL      string
+	Received int64
+	Total    int64
+	Done     bool
+	File     *os.File
+	Err      error
+}
+
+// pendingTransfer lets a second Submit for a URL already in flight piggyback
+// on the first one instead of downloading it twice.
+type pendingTransfer struct {
+	done   chan struct{}
+	result TransferEvent
+}
+
+// TransferManager downloads images over a bounded worker pool of HTTP range
+// requests, retrying each chunk with exponential backoff and jitter, and can
+// resume a partially downloaded file (including across process restarts,
+// since the destination name is derived from the URL) using Range/If-Range.
+// It dedupes concurrent requests for the same URL.
+type TransferManager struct {
+	Workers    int
+	ChunkSize  int64
+	MaxRetries int
+	Client     *http.Client
+
+	mu       sync.Mutex
+	inFlight map[string]*pendingTransfer
+}
+
+func NewTransferManager(workers int, chunkSize int64, maxRetries int) *TransferManager {
+	if workers <= 0 {
+		workers = DefaultTransferWorkers
+	}
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if maxRetries <= 0 {
+		maxRetries = DefaultMaxRetries
+	}
+
+	return &TransferManager{
+		Workers:    workers,
+		ChunkSize:  chunkSize,
+		MaxRetries: maxRetries,
+		Client:     &http.Client{},
+		inFlight:   make(map[string]*pendingTransfer),
+	}
+}
+
+// destPath derives a stable temp file name from the URL so that a restarted
+// process resumes the same partial download instead of starting a new one.
+func destPath(basePath, url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(basePath, fmt.Sprintf("transfer-%x.part", sum))
+}
+
+// Submit starts (or joins, if one is already in flight) the download of url
+// into basePath, returning a channel of progress events terminated by
+// exactly one event with Done true or Err set.
+func (tm *TransferManager) Submit(ctx context.Context, url, basePath string) <-chan TransferEvent {
+	out := make(chan TransferEvent, 8)
+
+	tm.mu.Lock()
+	if pending, ok := tm.inFlight[url]; ok {
+		tm.mu.Unlock()
+		go func() {
+			<-pending.done
+			out <- pending.result
+			close(out)
+		}()
+		return out
+	}
+
+	pending := &pendingTransfer{done: make(chan struct{})}
+	tm.inFlight[url] = pending
+	tm.mu.Unlock()
+
+	go func() {
+		defer close(out)
+		pending.result = tm.download(ctx, url, basePath, out)
+		tm.mu.Lock()
+		delete(tm.inFlight, url)
+		tm.mu.Unlock()
+		out <- pending.result
+		close(pending.done)
+	}()
+
+	return out
+}
+
+func (tm *TransferManager) download(ctx context.Context, url, basePath string, progress chan<- TransferEvent) TransferEvent {
+	path := destPath(basePath, url)
+
+	head, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return TransferEvent{URL: url, Err: err}
+	}
+
+	resp, err := tm.Client.Do(head)
+	if err != nil {
+		return TransferEvent{URL: url, Err: err}
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return TransferEvent{URL: url, Err: fmt.Errorf("HEAD %s: %s", url, resp.Status)}
+	}
+
+	total := resp.ContentLength
+	rangeable := resp.Header.Get("Accept-Ranges") == "bytes" && total > 0
+
+	// If-Range only matches the server's own validator: its ETag, or failing
+	// that its Last-Modified date.
+	validator := resp.Header.Get("ETag")
+	if validator == "" {
+		validator = resp.Header.Get("Last-Modified")
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return TransferEvent{URL: url, Err: err}
+	}
+
+	existing, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return TransferEvent{URL: url, Err: err}
+	}
+
+	if !rangeable || existing.Size() >= total {
+		if existing.Size() != total {
+			if err := tm.fetchWhole(ctx, url, validator, file, progress); err != nil {
+				file.Close()
+				return TransferEvent{URL: url, Err: err}
+			}
+		}
+		return TransferEvent{URL: url, Done: true, Total: total, Received: total, File: file}
+	}
+
+	if err := file.Truncate(total); err != nil {
+		file.Close()
+		return TransferEvent{URL: url, Err: err}
+	}
+
+	if err := tm.fetchRanged(ctx, url, file, existing.Size(), total, progress); err != nil {
+		file.Close()
+		return TransferEvent{URL: url, Err: err}
+	}
+
+	return TransferEvent{URL: url, Done: true, Total: total, Received: total, File: file}
+}
+
+// fetchWhole is the fallback path for servers that don't advertise
+// Accept-Ranges: it resumes via If-Range/Range when a partial file already
+// exists, but otherwise streams the body in one shot.
+func (tm *TransferManager) fetchWhole(ctx context.Context, url, validator string, file *os.File, progress chan<- TransferEvent) error {
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	return tm.retry(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			if validator != "" {
+				req.Header.Set("If-Range", validator)
+			}
+		}
+
+		resp, err := tm.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			return retryableStatusError(resp.StatusCode, resp.Status)
+		}
+
+		if resp.StatusCode == http.StatusOK && offset > 0 {
+			// The server ignored our Range request (no resume support after
+			// all): start this attempt over from scratch.
+			offset = 0
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			if err := file.Truncate(0); err != nil {
+				return err
+			}
+		}
+
+		written, err := io.Copy(&offsetWriter{file: file, offset: offset}, resp.Body)
+		offset += written
+		progress <- TransferEvent{URL: url, Received: offset}
+		return err
+	})
+}
+
+// fetchRanged splits [from, total) into ChunkSize pieces and downloads them
+// concurrently across a bounded worker pool, each chunk retried
+// independently.
+func (tm *TransferManager) fetchRanged(ctx context.Context, url string, file *os.File, from, total int64, progress chan<- TransferEvent) error {
+	sem := make(chan struct{}, tm.Workers)
+	var wg sync.WaitGroup
+	var received int64
+	var mu sync.Mutex
+	var firstErr error
+
+	for start := from; start < total; start += tm.ChunkSize {
+		end := start + tm.ChunkSize - 1
+		if end >= total {
+			end = total - 1
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(start, end int64) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := tm.fetchChunk(ctx, url, file, start, end)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			received += end - start + 1
+			progress <- TransferEvent{URL: url, Received: received, Total: total}
+		}(start, end)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+func (tm *TransferManager) fetchChunk(ctx context.Context, url string, file *os.File, start, end int64) error {
+	return tm.retry(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+		resp, err := tm.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusPartialContent {
+			return retryableStatusError(resp.StatusCode, resp.Status)
+		}
+
+		written, err := io.Copy(&offsetWriter{file: file, offset: start}, resp.Body)
+		if err != nil {
+			return err
+		}
+		if want := end - start + 1; written != want {
+			return fmt.Errorf("short read for range %d-%d: got %d of %d bytes", start, end, written, want)
+		}
+		return nil
+	})
+}
+
+// retryableError wraps an HTTP status that's worth retrying (5xx, 429); any
+// other status is treated as permanent.
+type retryableError struct {
+	status string
+	code   int
+}
+
+func (e *retryableError) Error() string { return e.status }
+
+func retryableStatusError(code int, status string) error {
+	return &retryableError{status: fmt.Sprintf("unexpected status: %s", status), code: code}
+}
+
+func isRetryable(err error) bool {
+	if rerr, ok := err.(*retryableError); ok {
+		return rerr.code >= 500 || rerr.code == http.StatusTooManyRequests
+	}
+	return err != context.Canceled && err != context.DeadlineExceeded
+}
+
+// retry runs fn with exponential backoff and jitter between attempts,
+// bailing out early on non-retryable errors or context cancellation.
+func (tm *TransferManager) retry(ctx context.Context, fn func() error) error {
+	var lastErr error
+
+	for attempt := 0; attempt <= tm.MaxRetries; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(math.Pow(2, float64(attempt))) * 100 * time.Millisecond
+			jitter := time.Duration(rand.Int63n(int64(backoff)))
+			log.Debugf("retrying transfer chunk, attempt %d, backing off %s", attempt, backoff+jitter)
+
+			select {
+			case <-time.After(backoff + jitter):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err := fn(); err != nil {
+			lastErr = err
+			if !isRetryable(err) {
+				return err
+			}
+			continue
+		}
+
+		return nil
+	}
+
+	return lastErr
+}
+
+// offsetWriter adapts an *os.File to io.Writer, writing sequentially
+// starting at a fixed byte offset so range chunks can be written
+// concurrently to disjoint regions of the same file.
+type offsetWriter struct {
+	file   *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.file.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const UbuntuCoreImagesBaseURL = "https://cdimage.ubuntu.com/ubuntu-core"
+
+// UbuntuCoreImageFetcher produces bootable OpenStack images for Ubuntu Core
+// releases. Upstream only publishes preinstalled device images for a
+// handful of boards, so when the requested architecture isn't one of them,
+// Fetch assembles one locally with `snap prepare-image` against Model
+// instead of downloading.
+type UbuntuCoreImageFetcher struct {
+	BaseImageFetcher
+	Track, Channel, Model string
+
+	published bool // set by GetImageURL once it's checked upstream
+	checksums map[string]string
+}
+
+func (fetcher *UbuntuCoreImageFetcher) imageFileName() string {
+	return fmt.Sprintf("ubuntu-core-%s-%s.img.xz", fetcher.Release, fetcher.Architecture)
+}
+
+func (fetcher *UbuntuCoreImageFetcher) channelDir() string {
+	return fmt.Sprintf("%s/%s/stable/current", UbuntuCoreImagesBaseURL, fetcher.Release)
+}
+
+func (fetcher *UbuntuCoreImageFetcher) candidateURL() string {
+	return fmt.Sprintf("%s/%s", fetcher.channelDir(), fetcher.imageFileName())
+}
+
+// GetImageURL returns the upstream preinstalled image URL if one exists for
+// this architecture, or "" (with no error) when Fetch should assemble the
+// image locally instead.
+func (fetcher *UbuntuCoreImageFetcher) GetImageURL() (string, error) {
+	url := fetcher.candidateURL()
+
+	if _, err := httpContentLength(url); err != nil {
+		log.Infof("%s: no preinstalled image upstream (%s), will assemble locally", fetcher.GetName(), err)
+		fetcher.published = false
+		return "", nil
+	}
+
+	fetcher.published = true
+	return url, nil
+}
+
+func (fetcher *UbuntuCoreImageFetcher) loadChecksums() error {
+	if fetcher.checksums != nil {
+		return nil
+	}
+
+	data, err := httpGetBytes(fetcher.channelDir() + "/SHA256SUMS")
+	if err != nil {
+		return err
+	}
+
+	fetcher.checksums = parseChecksumManifest(data)
+	return nil
+}
+
+func (fetcher *UbuntuCoreImageFetcher) ExpectedChecksum() (string, string, error) {
+	if !fetcher.published {
+		return "", "", nil
+	}
+
+	if err := fetcher.loadChecksums(); err != nil {
+		return "", "", err
+	}
+
+	digest, ok := fetcher.checksums[fetcher.imageFileName()]
+	if !ok {
+		return "", "", fmt.Errorf("no SHA256SUMS entry for %s", fetcher.imageFileName())
+	}
+
+	return "sha256", digest, nil
+}
+
+func (fetcher *UbuntuCoreImageFetcher) ExpectedSize() (int64, error) {
+	if !fetcher.published {
+		return 0, nil
+	}
+	return httpContentLength(fetcher.candidateURL())
+}
+
+// UpstreamSerial returns "" for the unpublished case: assembleLocally stamps
+// Image.Serial itself once it actually knows what it built.
+func (fetcher *UbuntuCoreImageFetcher) UpstreamSerial() (string, error) {
+	if !fetcher.published {
+		return "", nil
+	}
+	return httpLastModifiedSerial(fetcher.candidateURL())
+}
+
+// Fetch downloads the published image through the shared TransferManager
+// the same way every other fetcher does, or, when upstream has nothing for
+// this architecture, builds one with `snap prepare-image` against Model and
+// converts the resulting seed image to qcow2.
+func (fetcher *UbuntuCoreImageFetcher) Fetch(imageURL string, errChannel *chan error) {
+	if imageURL != "" {
+		fetcher.BaseImageFetcher.Fetch(imageURL, errChannel)
+		return
+	}
+
+	defer fetcher.WaitGroup.Done()
+
+	if err := fetcher.assembleLocally(); err != nil {
+		*errChannel <- fmt.Errorf("%s: %s", fetcher.GetName(), err)
+	}
+}
+
+func (fetcher *UbuntuCoreImageFetcher) assembleLocally() error {
+	if fetcher.Model == "" {
+		return fmt.Errorf("no model assertion configured for %s/%s", fetcher.Release, fetcher.Architecture)
+	}
+
+	channel := fetcher.Track
+	if fetcher.Channel != "" {
+		channel = fmt.Sprintf("%s/%s", fetcher.Track, fetcher.Channel)
+	}
+
+	seedDir, err := ioutil.TempDir(fetcher.ImageBasePath, "seed")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(seedDir)
+
+	log.Infof("%s: assembling image via snap prepare-image --channel=%s", fetcher.GetName(), channel)
+
+	cmd := exec.CommandContext(fetcher.Ctx, "snap", "prepare-image",
+		"--classic=false", "--channel="+channel, fetcher.Model, seedDir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("snap prepare-image failed: %s: %s", err, out)
+	}
+
+	seedImage := filepath.Join(seedDir, "ubuntu-seed.img")
+
+	qcow2, err := ioutil.TempFile(fetcher.ImageBasePath, "image")
+	if err != nil {
+		return err
+	}
+	qcow2.Close()
+
+	convert := exec.CommandContext(fetcher.Ctx, "qemu-img", "convert", "-O", "qcow2", seedImage, qcow2.Name())
+	if out, err := convert.CombinedOutput(); err != nil {
+		os.Remove(qcow2.Name())
+		return fmt.Errorf("qemu-img convert failed: %s: %s", err, out)
+	}
+
+	file, err := os.Open(qcow2.Name())
+	if err != nil {
+		return err
+	}
+
+	*fetcher.ImagesChannel <- Image{
+		Distro:       fetcher.Name,
+		Release:      fetcher.Release,
+		Architecture: fetcher.Architecture,
+		File:         file,
+		Serial:       "local-" + time.Now().Format("20060102"),
+	}
+	return nil
+}
+
+func NewUbuntuCoreImageFetcher(release, architecture, basepath string, wg *sync.WaitGroup, imagesChannel *chan Image, errorChannel *chan error, transferManager *TransferManager, ctx context.Context, track, channel, model string) (*UbuntuCoreImageFetcher, error) {
+	dir, err := ioutil.TempDir(basepath, "ubuntu-core")
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher := &UbuntuCoreImageFetcher{
+		BaseImageFetcher: BaseImageFetcher{
+			ErrorChannel:    errorChannel,
+			ImagesChannel:   imagesChannel,
+			WaitGroup:       wg,
+			TransferManager: transferManager,
+			Ctx:             ctx,
+			ImageBasePath:   dir, Name: "ubuntu-core", Architecture: architecture, Release: release,
+		},
+		Track:   track,
+		Channel: channel,
+		Model:   model,
+	}
+	fetcher.Self = fetcher
+	return fetcher, nil
+}
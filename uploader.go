@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+)
+
+// UploadResult carries the outcome of publishing an Image to a single
+// backend. ID and Location are backend-specific (Glance image UUID, AMI id,
+// SIG image version name, GCE image name, ...).
+type UploadResult struct {
+	Backend  string
+	ID       string
+	Location string
+}
+
+// Uploader publishes a fetched Image to a single cloud backend. Every
+// backend (Glance, AWS, Azure, GCP, ...) implements this so that
+// ImageUploadHandler.Handle can fan a single fetched Image out to every
+// backend configured for its distro/release without knowing about any of
+// them. Deciding which fetchers still need to run lives entirely in
+// ImageUploadHandler.FilterFetchers, which is state/checksum-aware across
+// every backend configured for a release; an Uploader has no filtering
+// method of its own to avoid a second, unused filtering path alongside it.
+type Uploader interface {
+	Upload(ctx context.Context, image *Image) (*UploadResult, error)
+	// Name identifies this backend ("glance", "aws", "azure", "gcp", ...)
+	// for scoping per-backend StateStore records.
+	Name() string
+}